@@ -30,6 +30,22 @@ var stringToPacketType = map[string]qt.PacketType {
 
 var frameTypeToFrame = map[qt.FrameType]qt.Frame {
 	qt.PaddingFrameType: new(qt.PaddingFrame),
+	qt.ResetStreamType: new(qt.ResetStream),
+	qt.ConnectionCloseType: new(qt.ConnectionCloseFrame),
+	qt.ApplicationCloseType: new(qt.ApplicationCloseFrame),
+	qt.MaxDataType: new(qt.MaxDataFrame),
+	qt.MaxStreamDataType: new(qt.MaxStreamDataFrame),
+	qt.MaxStreamIdType: new(qt.MaxStreamIdFrame),
+	qt.PingType: new(qt.PingFrame),
+	qt.BlockedType: new(qt.BlockedFrame),
+	qt.StreamBlockedType: new(qt.StreamBlockedFrame),
+	qt.StreamIdBlockedType: new(qt.StreamIdBlockedFrame),
+	qt.NewConnectionIdType: new(qt.NewConnectionIdFrame),
+	qt.StopSendingType: new(qt.StopSendingFrame),
+	qt.PongType: new(qt.PongFrame),
+	qt.AckType: qt.NewAckFrame(0, 0),
+	// StreamType is deliberately absent: building one requires a live
+	// *qt.Stream to track the write offset, which PacketBuilder doesn't have.
 }
 
 type HeaderOptions struct {
@@ -52,17 +68,30 @@ type AbstractSymbol struct {
 	packetType qt.PacketType
 	headerOptions HeaderOptions
 	frameTypes mapset.Set // type: qt.FrameType
-}
-
+	// verboseFrames is a pre-rendered, comma-joined, sorted list of each
+	// frame's DebugString, set by NewVerboseAbstractSymbol. It must stay a
+	// plain string rather than []qt.Frame: AbstractSymbol is stored as an
+	// AbstractSet element, i.e. a golang-set map key, and a slice field
+	// would make it unhashable.
+	verboseFrames string
+}
+
+// String renders the symbol using only frame types, e.g. INITIAL(0xff00001d)[ACK,CRYPTO].
+// If the symbol was built with NewVerboseAbstractSymbol, it renders each
+// frame's DebugString instead, e.g. INITIAL(0xff00001d)[ACK(largest=3,...),CRYPTO(...)],
+// so an inference trace can actually be diagnosed when a server deviates.
 func (as AbstractSymbol) String() string {
 	packetType := packetTypeToString[as.packetType]
 	headerOptions := as.headerOptions.String()
-	frameStrings := []string{}
-	for _, frameType := range as.frameTypes.ToSlice() {
-		frameStrings = append(frameStrings, frameType.(qt.FrameType).String())
+	frameTypes := as.verboseFrames
+	if frameTypes == "" {
+		frameStrings := []string{}
+		for _, frameType := range as.frameTypes.ToSlice() {
+			frameStrings = append(frameStrings, frameType.(qt.FrameType).String())
+		}
+		sort.Strings(frameStrings)
+		frameTypes = strings.Join(frameStrings, ",")
 	}
-	sort.Strings(frameStrings)
-	frameTypes := strings.Join(frameStrings, ",")
 	return fmt.Sprintf("%v(%v)[%v]", packetType, headerOptions, frameTypes)
 }
 
@@ -74,6 +103,22 @@ func NewAbstractSymbol(packetType qt.PacketType, headerOptions HeaderOptions, fr
 	}
 }
 
+// NewVerboseAbstractSymbol is like NewAbstractSymbol but pre-renders each
+// frame's DebugString so String can print key frame fields instead of bare
+// types. The concrete frames themselves aren't kept on the struct, since
+// that would make AbstractSymbol unhashable (see verboseFrames).
+func NewVerboseAbstractSymbol(packetType qt.PacketType, headerOptions HeaderOptions, frameTypes mapset.Set, frames []qt.Frame) AbstractSymbol {
+	frameStrings := make([]string, 0, len(frames))
+	for _, frame := range frames {
+		frameStrings = append(frameStrings, qt.DebugString(frame))
+	}
+	sort.Strings(frameStrings)
+
+	as := NewAbstractSymbol(packetType, headerOptions, frameTypes)
+	as.verboseFrames = strings.Join(frameStrings, ",")
+	return as
+}
+
 func NewAbstractSymbolFromString(message string) AbstractSymbol {
 	messageStringRegex := regexp.MustCompile(`^([A-Z]+)(\(([0-9a-zx]+)\))?\[([A-Z,]+)\]$`)
 	subgroups := messageStringRegex.FindStringSubmatch(message)
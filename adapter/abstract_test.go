@@ -0,0 +1,24 @@
+package adapter
+
+import (
+	mapset "github.com/deckarep/golang-set"
+	qt "github.com/tiferrei/quic-tracker"
+	"testing"
+)
+
+// AbstractSymbol is stored as an AbstractSet element, i.e. a golang-set map
+// key; it must stay comparable or set.Add panics at runtime with "hash of
+// unhashable type".
+func TestVerboseAbstractSymbolIsHashable(t *testing.T) {
+	frameTypes := mapset.NewSet()
+	frameTypes.Add(qt.PingType)
+	symbol := NewVerboseAbstractSymbol(qt.Initial, HeaderOptions{}, frameTypes, []qt.Frame{new(qt.PingFrame)})
+
+	set := AbstractSet{mapset.NewSet()}
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("Add panicked on a verbose AbstractSymbol: %v", r)
+		}
+	}()
+	set.Add(symbol)
+}
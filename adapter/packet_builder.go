@@ -0,0 +1,49 @@
+package adapter
+
+import (
+	qt "github.com/tiferrei/quic-tracker"
+)
+
+// PacketBuilder greedily packs frames into a single packet up to a target
+// size, so test cases can exercise a server's handling of maximum-density
+// packets instead of sending one frame at a time.
+type PacketBuilder struct {
+	targetSize int
+	used       int
+	frames     []qt.Frame
+}
+
+// NewPacketBuilder returns a PacketBuilder that packs frames into a packet
+// of at most targetSize bytes.
+func NewPacketBuilder(targetSize int) *PacketBuilder {
+	return &PacketBuilder{targetSize: targetSize}
+}
+
+// Frames returns the frames packed so far, including any trailing padding
+// added by Pack.
+func (pb *PacketBuilder) Frames() []qt.Frame { return pb.frames }
+
+// Pack resolves each frameType to a concrete frame via frameTypeToFrame and
+// greedily appends it if it still fits in targetSize, then pads the
+// remainder of the packet with PaddingFrame. It returns the frame types
+// that didn't fit, either because they have no known concrete
+// representation or because there wasn't enough room left.
+func (pb *PacketBuilder) Pack(frameTypes []qt.FrameType) (dropped []qt.FrameType) {
+	for _, frameType := range frameTypes {
+		frame, ok := frameTypeToFrame[frameType]
+		if !ok || pb.used+frame.Size() > pb.targetSize {
+			dropped = append(dropped, frameType)
+			continue
+		}
+		pb.frames = append(pb.frames, frame)
+		pb.used += frame.Size()
+	}
+
+	for pb.used < pb.targetSize {
+		padding := new(qt.PaddingFrame)
+		pb.frames = append(pb.frames, padding)
+		pb.used += padding.Size()
+	}
+
+	return dropped
+}
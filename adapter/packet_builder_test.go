@@ -0,0 +1,32 @@
+package adapter
+
+import (
+	qt "github.com/tiferrei/quic-tracker"
+	"testing"
+)
+
+func TestPacketBuilderPacksMultipleFrameTypes(t *testing.T) {
+	pb := NewPacketBuilder(64)
+	want := []qt.FrameType{qt.PingType, qt.MaxDataType, qt.BlockedType}
+	if dropped := pb.Pack(want); len(dropped) != 0 {
+		t.Fatalf("dropped frames that should have fit: %v", dropped)
+	}
+
+	seen := map[qt.FrameType]bool{}
+	for _, frame := range pb.Frames() {
+		seen[frame.FrameType()] = true
+	}
+	for _, frameType := range want {
+		if !seen[frameType] {
+			t.Errorf("packed frames missing type %#x", frameType)
+		}
+	}
+}
+
+func TestPacketBuilderDropsFramesThatDontFit(t *testing.T) {
+	pb := NewPacketBuilder(1) // too small for anything but a single PADDING byte
+	dropped := pb.Pack([]qt.FrameType{qt.MaxStreamIdType})
+	if len(dropped) != 1 || dropped[0] != qt.MaxStreamIdType {
+		t.Fatalf("got dropped=%v, want [%#x]", dropped, qt.MaxStreamIdType)
+	}
+}
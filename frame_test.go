@@ -0,0 +1,323 @@
+package masterthesis
+
+import (
+	"bytes"
+	"testing"
+)
+
+// varIntBoundaries covers the edges of each WriteVarInt/ReadVarInt length
+// class: 1, 2, 4 and 8 byte encodings.
+var varIntBoundaries = []uint64{0, 63, 16383, 1<<30 - 1, 1<<62 - 1}
+
+// encode serializes frame and checks that Size() agrees with what writeTo
+// actually wrote.
+func encode(t *testing.T, frame Frame) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	frame.writeTo(buf)
+	if got, want := buf.Len(), frame.Size(); got != want {
+		t.Errorf("Size() = %d, writeTo wrote %d bytes", want, got)
+	}
+	return buf.Bytes()
+}
+
+func TestPaddingFrameRoundTrip(t *testing.T) {
+	raw := encode(t, new(PaddingFrame))
+	if _, err := NewPaddingFrame(bytes.NewReader(raw)); err != nil {
+		t.Fatalf("NewPaddingFrame: %v", err)
+	}
+}
+
+func TestResetStreamRoundTrip(t *testing.T) {
+	for _, streamId := range varIntBoundaries {
+		for _, finalOffset := range varIntBoundaries {
+			want := &ResetStream{streamId: streamId, errorCode: 0x42, finalOffset: finalOffset}
+			got, err := NewResetStream(bytes.NewReader(encode(t, want)))
+			if err != nil {
+				t.Fatalf("streamId=%d finalOffset=%d: %v", streamId, finalOffset, err)
+			}
+			if *got != *want {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		}
+	}
+}
+
+func TestConnectionCloseFrameRoundTrip(t *testing.T) {
+	for _, reason := range []string{"", "protocol_violation", string(make([]byte, maxReasonPhraseLength))} {
+		want := &ConnectionCloseFrame{errorCode: 0x0a, reasonPhraseLength: uint64(len(reason)), reasonPhrase: reason}
+		got, err := NewConnectionCloseFrame(bytes.NewReader(encode(t, want)))
+		if err != nil {
+			t.Fatalf("len(reason)=%d: %v", len(reason), err)
+		}
+		if *got != *want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestApplicationCloseFrameRoundTrip(t *testing.T) {
+	for _, reason := range []string{"", "application_error"} {
+		want := &ApplicationCloseFrame{errorCode: 0x01, reasonPhraseLength: uint64(len(reason)), reasonPhrase: reason}
+		got, err := NewApplicationCloseFrame(bytes.NewReader(encode(t, want)))
+		if err != nil {
+			t.Fatalf("len(reason)=%d: %v", len(reason), err)
+		}
+		if *got != *want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestMaxDataFrameRoundTrip(t *testing.T) {
+	for _, max := range varIntBoundaries {
+		want := &MaxDataFrame{maximumData: max}
+		got, err := NewMaxDataFrame(bytes.NewReader(encode(t, want)))
+		if err != nil {
+			t.Fatalf("maximumData=%d: %v", max, err)
+		}
+		if *got != *want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestMaxStreamDataFrameRoundTrip(t *testing.T) {
+	want := &MaxStreamDataFrame{streamId: 4, maximumStreamData: 1 << 30}
+	got, err := NewMaxStreamDataFrame(bytes.NewReader(encode(t, want)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestMaxStreamIdFrameRoundTrip(t *testing.T) {
+	want := &MaxStreamIdFrame{maximumStreamId: 16383}
+	got, err := NewMaxStreamIdFrame(bytes.NewReader(encode(t, want)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestPingFrameRoundTrip(t *testing.T) {
+	for _, length := range []uint8{0, 1, 255} {
+		want := &PingFrame{length: length, data: make([]byte, length, length)}
+		got, err := NewPingFrame(bytes.NewReader(encode(t, want)))
+		if err != nil {
+			t.Fatalf("length=%d: %v", length, err)
+		}
+		if got.length != want.length || !bytes.Equal(got.data, want.data) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestPongFrameRoundTrip(t *testing.T) {
+	want := &PongFrame{PingFrame{length: 4, data: []byte{1, 2, 3, 4}}}
+	got, err := NewPongFrame(bytes.NewReader(encode(t, want)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.length != want.length || !bytes.Equal(got.data, want.data) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestBlockedFrameRoundTrip(t *testing.T) {
+	for _, offset := range varIntBoundaries {
+		want := &BlockedFrame{offset: offset}
+		got, err := NewBlockedFrame(bytes.NewReader(encode(t, want)))
+		if err != nil {
+			t.Fatalf("offset=%d: %v", offset, err)
+		}
+		if *got != *want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestStreamBlockedFrameRoundTrip(t *testing.T) {
+	want := &StreamBlockedFrame{streamId: 7, offset: 1 << 20}
+	got, err := NewStreamBlockedFrame(bytes.NewReader(encode(t, want)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestStreamIdBlockedFrameRoundTrip(t *testing.T) {
+	want := &StreamIdBlockedFrame{streamId: 11}
+	got, err := NewStreamIdNeededFrame(bytes.NewReader(encode(t, want)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestNewConnectionIdFrameRoundTrip(t *testing.T) {
+	want := &NewConnectionIdFrame{sequence: 2, connectionId: 0xdeadbeef, statelessResetToken: [16]byte{1, 2, 3}}
+	got, err := NewNewConnectionIdFrame(bytes.NewReader(encode(t, want)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestStopSendingFrameRoundTrip(t *testing.T) {
+	want := &StopSendingFrame{streamId: 3, errorCode: 0x07}
+	got, err := NewStopSendingFrame(bytes.NewReader(encode(t, want)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestAckFrameRoundTrip(t *testing.T) {
+	want := &AckFrame{
+		largestAcknowledged: 100,
+		ackDelay:            42,
+		ackRanges: []AckRange{
+			{start: 90, end: 100},
+			{start: 70, end: 80},
+			{start: 0, end: 50},
+		},
+	}
+	var seen []AckRange
+	got, err := ReadAckFrame(bytes.NewReader(encode(t, want)), func(start, end uint64) {
+		seen = append(seen, AckRange{start: start, end: end})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.largestAcknowledged != want.largestAcknowledged || got.ackDelay != want.ackDelay {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	for i, r := range want.ackRanges {
+		if got.ackRanges[i] != r {
+			t.Errorf("range %d: got %+v, want %+v", i, got.ackRanges[i], r)
+		}
+		if seen[i] != r {
+			t.Errorf("onRange %d: got %+v, want %+v", i, seen[i], r)
+		}
+	}
+	for _, pn := range []uint64{0, 50, 75, 80, 90, 100} {
+		if !got.Contains(pn) {
+			t.Errorf("Contains(%d) = false, want true", pn)
+		}
+	}
+	for _, pn := range []uint64{51, 81, 101} {
+		if got.Contains(pn) {
+			t.Errorf("Contains(%d) = true, want false", pn)
+		}
+	}
+}
+
+func TestAckFrameRejectsOverlappingRanges(t *testing.T) {
+	frame := new(AckFrame)
+	if err := frame.appendRange(10, 20); err != nil {
+		t.Fatalf("first range: %v", err)
+	}
+	if err := frame.appendRange(15, 18); err != ErrInvalidAckRange {
+		t.Errorf("overlapping range: got %v, want ErrInvalidAckRange", err)
+	}
+	if err := frame.appendRange(0, 10); err != ErrInvalidAckRange {
+		t.Errorf("touching range: got %v, want ErrInvalidAckRange", err)
+	}
+	if err := frame.appendRange(0, 9); err != ErrInvalidAckRange {
+		t.Errorf("adjacent range (no gap): got %v, want ErrInvalidAckRange", err)
+	}
+}
+
+// TestAckFrameZeroRangePanics pins the AckFrame invariant documented on the
+// type: Size and writeTo both assume at least one AckRange has been appended
+// and panic identically when that doesn't hold, rather than silently
+// disagreeing about whether the frame is serializable.
+func TestAckFrameZeroRangePanics(t *testing.T) {
+	assertPanics := func(name string, fn func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s: got no panic, want one", name)
+			}
+		}()
+		fn()
+	}
+
+	frame := new(AckFrame)
+	assertPanics("Size", func() { frame.Size() })
+	assertPanics("writeTo", func() { frame.writeTo(new(bytes.Buffer)) })
+}
+
+func TestReadStreamFrameRejectsOversizedLength(t *testing.T) {
+	// lenBit set, streamId 0, and a length varint (0x3fffffff) that vastly
+	// exceeds the zero bytes left in the buffer; ReadStreamFrame must reject
+	// it with ErrTruncatedFrame before allocating a slice of that length.
+	raw := []byte{0x12, 0x00, 0xbf, 0xff, 0xff, 0xff}
+	if _, err := ReadStreamFrame(bytes.NewReader(raw), nil); err != ErrTruncatedFrame {
+		t.Errorf("got %v, want ErrTruncatedFrame", err)
+	}
+}
+
+func TestStreamFrameRoundTrip(t *testing.T) {
+	for _, fin := range []bool{false, true} {
+		for _, lenBit := range []bool{false, true} {
+			for _, offBit := range []bool{false, true} {
+				want := &StreamFrame{
+					finBit:     fin,
+					lenBit:     lenBit,
+					offBit:     offBit,
+					streamId:   9,
+					streamData: []byte("hello"),
+				}
+				if offBit {
+					want.offset = 1234
+				}
+				if lenBit {
+					want.length = uint64(len(want.streamData))
+				}
+				raw := encode(t, want)
+				// ReadStreamFrame relies on the buffer ending at the frame's
+				// boundary when lenBit is unset, since length then runs to
+				// the end of the packet.
+				got, err := ReadStreamFrame(bytes.NewReader(raw), nil)
+				if err != nil {
+					t.Fatalf("fin=%v len=%v off=%v: %v", fin, lenBit, offBit, err)
+				}
+				if got.finBit != want.finBit || got.lenBit != want.lenBit || got.offBit != want.offBit ||
+					got.streamId != want.streamId || got.offset != want.offset ||
+					!bytes.Equal(got.streamData, want.streamData) {
+					t.Errorf("got %+v (data=%q), want %+v (data=%q)", got, got.streamData, want, want.streamData)
+				}
+			}
+		}
+	}
+}
+
+// FuzzNewFrame feeds random bytes to Framer.ReadFrame and asserts it never
+// panics, regardless of how malformed or adversarial the input is.
+func FuzzNewFrame(f *testing.F) {
+	f.Add([]byte{0x00})
+	f.Add([]byte{0x01, 0x04, 0x00, 0x01, 0x05, 0x2a})
+	f.Add([]byte{0x0e, 0x0a, 0x00, 0x00, 0x00})
+	f.Add([]byte{0xff})
+	// STREAM frame (type|lenBit) advertising a length far larger than the
+	// bytes actually remaining in the buffer.
+	f.Add([]byte{0x12, 0x00, 0xbf, 0xff, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		framer := NewFramer(bytes.NewReader(data), nil, nil)
+		framer.ReadFrame()
+	})
+}
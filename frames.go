@@ -3,60 +3,162 @@ package masterthesis
 import (
 	"bytes"
 	"encoding/binary"
-	"io"
 	"fmt"
-	"github.com/davecgh/go-spew/spew"
+	"io"
+)
+
+// FramerError is a typed error enum returned by Framer and the individual
+// frame constructors. Unlike the previous behaviour, malformed input never
+// triggers a panic: callers get back a FramerError they can record as a
+// distinct AbstractSymbol outcome instead of crashing the test.
+type FramerError string
+
+func (e FramerError) Error() string { return string(e) }
+
+const (
+	// ErrUnknownFrameType is returned when the leading type byte does not
+	// match any known frame type.
+	ErrUnknownFrameType FramerError = "quic-tracker: unknown frame type"
+	// ErrTruncatedFrame is returned when the buffer runs out of bytes
+	// while a frame is being decoded.
+	ErrTruncatedFrame FramerError = "quic-tracker: truncated frame"
+	// ErrInvalidVarInt is returned when a variable-length integer cannot
+	// be decoded from the buffer.
+	ErrInvalidVarInt FramerError = "quic-tracker: invalid varint"
+	// ErrReasonPhraseTooLong is returned when a CONNECTION_CLOSE or
+	// APPLICATION_CLOSE frame advertises a reason phrase larger than
+	// maxReasonPhraseLength, which is otherwise an easy way to make the
+	// tracker allocate an attacker-controlled amount of memory.
+	ErrReasonPhraseTooLong FramerError = "quic-tracker: reason phrase too long"
+	// ErrInvalidAckRange is returned when an ACK frame's ranges are out of
+	// order, overlapping, or reference packet numbers below zero.
+	ErrInvalidAckRange FramerError = "quic-tracker: invalid ack range"
 )
 
+// maxReasonPhraseLength bounds the reasonPhraseLength field of close
+// frames so a malicious peer can't force an unbounded allocation.
+const maxReasonPhraseLength = 1 << 16
+
 type Frame interface {
 	FrameType() FrameType
+	// Size returns the number of bytes writeTo would write, without
+	// actually serializing the frame. Used to pack frames into a packet
+	// up to a target size.
+	Size() int
 	writeTo(buffer *bytes.Buffer)
 }
-func NewFrame(buffer *bytes.Reader, conn *Connection) Frame {
-	typeByte, err := buffer.ReadByte()
+
+// varIntSize returns the number of bytes WriteVarInt uses to encode value,
+// per RFC 9000 §16: 1 byte up to 63, 2 up to 16383, 4 up to 2^30-1, and 8
+// otherwise.
+func varIntSize(value uint64) int {
+	switch {
+	case value <= 63:
+		return 1
+	case value <= 16383:
+		return 2
+	case value <= 1073741823:
+		return 4
+	default:
+		return 8
+	}
+}
+
+// debugFrame is implemented by every concrete frame type and exposes the
+// fields FrameType() alone can't show (error codes, reason phrases, stream
+// offsets...). It's kept separate from Frame, rather than folded into it,
+// so the hot serving path never boxes a Frame into an interface{} just to
+// check whether it's printable; only DebugString does that.
+type debugFrame interface {
+	String() string
+}
+
+// DebugString returns a detailed, human-readable representation of frame,
+// e.g. CONNECTION_CLOSE(err=0x1,reason="protocol_violation"). It falls
+// back to the bare FrameType if frame doesn't implement debugFrame.
+func DebugString(frame Frame) string {
+	if df, ok := frame.(debugFrame); ok {
+		return df.String()
+	}
+	return frame.FrameType().String()
+}
+
+// Framer turns a byte stream into Frame values and back, the way
+// golang.org/x/net/http2.Framer turns a byte stream into HTTP/2 frames. It
+// never panics: every decoding failure is surfaced as a FramerError so the
+// caller can decide what to do with a malformed or adversarial peer.
+type Framer struct {
+	reader *bytes.Reader
+	writer *bytes.Buffer
+	conn   *Connection
+}
+
+// NewFramer wraps reader and writer for a single packet's payload. writer
+// may be nil if the Framer is only going to be used to read frames.
+func NewFramer(reader *bytes.Reader, writer *bytes.Buffer, conn *Connection) *Framer {
+	return &Framer{reader: reader, writer: writer, conn: conn}
+}
+
+// ReadFrame decodes the next frame from the Framer's reader. It returns
+// (nil, io.EOF) once the reader is exhausted, and a FramerError if the
+// bytes read so far don't form a valid frame.
+func (fr *Framer) ReadFrame() (Frame, error) {
+	typeByte, err := fr.reader.ReadByte()
 	if err == io.EOF {
-		return nil
+		return nil, io.EOF
 	} else if err != nil {
-		panic(err)
+		return nil, ErrTruncatedFrame
 	}
-	buffer.UnreadByte()
+	fr.reader.UnreadByte()
+
 	frameType := FrameType(typeByte)
 	switch {
 	case frameType == PaddingFrameType:
-		return Frame(NewPaddingFrame(buffer))
+		return NewPaddingFrame(fr.reader)
 	case frameType == ResetStreamType:
-		return Frame(NewResetStream(buffer))
+		return NewResetStream(fr.reader)
 	case frameType == ConnectionCloseType:
-		return Frame(NewConnectionCloseFrame(buffer))
+		return NewConnectionCloseFrame(fr.reader)
 	case frameType == ApplicationCloseType:
-		return Frame(NewApplicationCloseFrame(buffer))
+		return NewApplicationCloseFrame(fr.reader)
 	case frameType == MaxDataType:
-		return Frame(NewMaxDataFrame(buffer))
+		return NewMaxDataFrame(fr.reader)
 	case frameType == MaxStreamDataType:
-		return Frame(NewMaxStreamDataFrame(buffer))
+		return NewMaxStreamDataFrame(fr.reader)
 	case frameType == MaxStreamIdType:
-		return Frame(NewMaxStreamIdFrame(buffer))
+		return NewMaxStreamIdFrame(fr.reader)
 	case frameType == PingType:
-		return Frame(NewPingFrame(buffer))
+		return NewPingFrame(fr.reader)
 	case frameType == BlockedType:
-		return Frame(NewBlockedFrame(buffer))
+		return NewBlockedFrame(fr.reader)
 	case frameType == StreamBlockedType:
-		return Frame(NewStreamBlockedFrame(buffer))
+		return NewStreamBlockedFrame(fr.reader)
 	case frameType == StreamIdBlockedType:
-		return Frame(NewStreamIdNeededFrame(buffer))
+		return NewStreamIdNeededFrame(fr.reader)
 	case frameType == NewConnectionIdType:
-		return Frame(NewNewConnectionIdFrame(buffer))
+		return NewNewConnectionIdFrame(fr.reader)
 	case frameType == StopSendingType:
-		return Frame(NewStopSendingFrame(buffer))
+		return NewStopSendingFrame(fr.reader)
+	case frameType == PongType:
+		return NewPongFrame(fr.reader)
 	case frameType == AckType:
-		return Frame(ReadAckFrame(buffer))
+		return ReadAckFrame(fr.reader, nil)
 	case (frameType & StreamType) == StreamType:
-		return Frame(ReadStreamFrame(buffer, conn))
+		return ReadStreamFrame(fr.reader, fr.conn)
 	default:
-		spew.Dump(buffer)
-		panic(fmt.Sprintf("Unknown frame type %d", typeByte))
+		return nil, ErrUnknownFrameType
+	}
+}
+
+// WriteFrame serializes frame onto the Framer's writer.
+func (fr *Framer) WriteFrame(frame Frame) error {
+	if fr.writer == nil {
+		return FramerError("quic-tracker: framer has no writer")
 	}
+	frame.writeTo(fr.writer)
+	return nil
 }
+
 type FrameType uint8
 
 const PaddingFrameType FrameType = 0x00
@@ -79,33 +181,53 @@ const StreamType FrameType = 0x10
 type PaddingFrame byte
 
 func (frame PaddingFrame) FrameType() FrameType { return PaddingFrameType }
+func (frame PaddingFrame) Size() int { return 1 }
 func (frame PaddingFrame) writeTo(buffer *bytes.Buffer) {
 	binary.Write(buffer, binary.BigEndian, frame.FrameType())
 }
-func NewPaddingFrame(buffer *bytes.Reader) *PaddingFrame {
-	buffer.ReadByte()  // Discard frame payload
-	return new(PaddingFrame)
+func NewPaddingFrame(buffer *bytes.Reader) (*PaddingFrame, error) {
+	if _, err := buffer.ReadByte(); err != nil { // Discard frame payload
+		return nil, ErrTruncatedFrame
+	}
+	return new(PaddingFrame), nil
 }
+func (frame PaddingFrame) String() string { return frame.FrameType().String() }
 
 type ResetStream struct {
 	streamId    uint64
 	errorCode   uint16
 	finalOffset uint64
 }
+
 func (frame ResetStream) FrameType() FrameType { return ResetStreamType }
+func (frame ResetStream) Size() int {
+	return 1 + varIntSize(frame.streamId) + 2 + varIntSize(frame.finalOffset)
+}
 func (frame ResetStream) writeTo(buffer *bytes.Buffer) {
 	binary.Write(buffer, binary.BigEndian, frame.FrameType())
 	WriteVarInt(buffer, frame.streamId)
 	binary.Write(buffer, binary.BigEndian, frame.errorCode)
 	WriteVarInt(buffer, frame.finalOffset)
 }
-func NewResetStream(buffer *bytes.Reader) *ResetStream {
+func NewResetStream(buffer *bytes.Reader) (*ResetStream, error) {
 	frame := new(ResetStream)
-	buffer.ReadByte()  // Discard frame type
-	frame.streamId, _ = ReadVarInt(buffer)
-	binary.Read(buffer, binary.BigEndian, &frame.errorCode)
-	frame.finalOffset, _ = ReadVarInt(buffer)
-	return frame
+	var err error
+	if _, err = buffer.ReadByte(); err != nil { // Discard frame type
+		return nil, ErrTruncatedFrame
+	}
+	if frame.streamId, err = ReadVarInt(buffer); err != nil {
+		return nil, ErrInvalidVarInt
+	}
+	if err = binary.Read(buffer, binary.BigEndian, &frame.errorCode); err != nil {
+		return nil, ErrTruncatedFrame
+	}
+	if frame.finalOffset, err = ReadVarInt(buffer); err != nil {
+		return nil, ErrInvalidVarInt
+	}
+	return frame, nil
+}
+func (frame ResetStream) String() string {
+	return fmt.Sprintf("%v(stream=%d,err=%#x,offset=%d)", frame.FrameType(), frame.streamId, frame.errorCode, frame.finalOffset)
 }
 
 type ConnectionCloseFrame struct {
@@ -113,7 +235,11 @@ type ConnectionCloseFrame struct {
 	reasonPhraseLength uint64
 	reasonPhrase       string
 }
+
 func (frame ConnectionCloseFrame) FrameType() FrameType { return ConnectionCloseType }
+func (frame ConnectionCloseFrame) Size() int {
+	return 1 + 2 + varIntSize(frame.reasonPhraseLength) + len(frame.reasonPhrase)
+}
 func (frame ConnectionCloseFrame) writeTo(buffer *bytes.Buffer) {
 	binary.Write(buffer, binary.BigEndian, frame.FrameType())
 	binary.Write(buffer, binary.BigEndian, frame.errorCode)
@@ -122,17 +248,32 @@ func (frame ConnectionCloseFrame) writeTo(buffer *bytes.Buffer) {
 		buffer.Write([]byte(frame.reasonPhrase))
 	}
 }
-func NewConnectionCloseFrame(buffer *bytes.Reader) *ConnectionCloseFrame {
+func NewConnectionCloseFrame(buffer *bytes.Reader) (*ConnectionCloseFrame, error) {
 	frame := new(ConnectionCloseFrame)
-	buffer.ReadByte()  // Discard frame type
-	binary.Read(buffer, binary.BigEndian, &frame.errorCode)
-	frame.reasonPhraseLength, _ = ReadVarInt(buffer)
+	var err error
+	if _, err = buffer.ReadByte(); err != nil { // Discard frame type
+		return nil, ErrTruncatedFrame
+	}
+	if err = binary.Read(buffer, binary.BigEndian, &frame.errorCode); err != nil {
+		return nil, ErrTruncatedFrame
+	}
+	if frame.reasonPhraseLength, err = ReadVarInt(buffer); err != nil {
+		return nil, ErrInvalidVarInt
+	}
+	if frame.reasonPhraseLength > maxReasonPhraseLength {
+		return nil, ErrReasonPhraseTooLong
+	}
 	if frame.reasonPhraseLength > 0 {
 		reasonBytes := make([]byte, frame.reasonPhraseLength, frame.reasonPhraseLength)
-		binary.Read(buffer, binary.BigEndian, &reasonBytes)
+		if err = binary.Read(buffer, binary.BigEndian, &reasonBytes); err != nil {
+			return nil, ErrTruncatedFrame
+		}
 		frame.reasonPhrase = string(reasonBytes)
 	}
-	return frame
+	return frame, nil
+}
+func (frame ConnectionCloseFrame) String() string {
+	return fmt.Sprintf("%v(err=%#x,reason=%q)", frame.FrameType(), frame.errorCode, frame.reasonPhrase)
 }
 
 type ApplicationCloseFrame struct {
@@ -140,84 +281,136 @@ type ApplicationCloseFrame struct {
 	reasonPhraseLength uint64
 	reasonPhrase       string
 }
+
 func (frame ApplicationCloseFrame) FrameType() FrameType { return ApplicationCloseType }
+func (frame ApplicationCloseFrame) Size() int {
+	return 1 + 2 + varIntSize(frame.reasonPhraseLength) + len(frame.reasonPhrase)
+}
 func (frame ApplicationCloseFrame) writeTo(buffer *bytes.Buffer) {
 	binary.Write(buffer, binary.BigEndian, frame.FrameType())
 	binary.Write(buffer, binary.BigEndian, frame.errorCode)
-	binary.Write(buffer, binary.BigEndian, frame.reasonPhraseLength)
+	WriteVarInt(buffer, frame.reasonPhraseLength)
 	if frame.reasonPhraseLength > 0 {
 		buffer.Write([]byte(frame.reasonPhrase))
 	}
 }
-func NewApplicationCloseFrame(buffer *bytes.Reader) *ApplicationCloseFrame {
+func NewApplicationCloseFrame(buffer *bytes.Reader) (*ApplicationCloseFrame, error) {
 	frame := new(ApplicationCloseFrame)
-	buffer.ReadByte()  // Discard frame type
-	binary.Read(buffer, binary.BigEndian, &frame.errorCode)
-	frame.reasonPhraseLength, _ = ReadVarInt(buffer)
+	var err error
+	if _, err = buffer.ReadByte(); err != nil { // Discard frame type
+		return nil, ErrTruncatedFrame
+	}
+	if err = binary.Read(buffer, binary.BigEndian, &frame.errorCode); err != nil {
+		return nil, ErrTruncatedFrame
+	}
+	if frame.reasonPhraseLength, err = ReadVarInt(buffer); err != nil {
+		return nil, ErrInvalidVarInt
+	}
+	if frame.reasonPhraseLength > maxReasonPhraseLength {
+		return nil, ErrReasonPhraseTooLong
+	}
 	if frame.reasonPhraseLength > 0 {
 		reasonBytes := make([]byte, frame.reasonPhraseLength, frame.reasonPhraseLength)
-		binary.Read(buffer, binary.BigEndian, &reasonBytes)
+		if err = binary.Read(buffer, binary.BigEndian, &reasonBytes); err != nil {
+			return nil, ErrTruncatedFrame
+		}
 		frame.reasonPhrase = string(reasonBytes)
 	}
-	return frame
+	return frame, nil
+}
+func (frame ApplicationCloseFrame) String() string {
+	return fmt.Sprintf("%v(err=%#x,reason=%q)", frame.FrameType(), frame.errorCode, frame.reasonPhrase)
 }
-
 
 type MaxDataFrame struct {
 	maximumData uint64
 }
+
 func (frame MaxDataFrame) FrameType() FrameType { return MaxDataType }
+func (frame MaxDataFrame) Size() int { return 1 + varIntSize(frame.maximumData) }
 func (frame MaxDataFrame) writeTo(buffer *bytes.Buffer) {
 	binary.Write(buffer, binary.BigEndian, frame.FrameType())
 	WriteVarInt(buffer, frame.maximumData)
 }
-func NewMaxDataFrame(buffer *bytes.Reader) *MaxDataFrame {
+func NewMaxDataFrame(buffer *bytes.Reader) (*MaxDataFrame, error) {
 	frame := new(MaxDataFrame)
-	buffer.ReadByte()  // Discard frame type
-	frame.maximumData, _ = ReadVarInt(buffer)
-	binary.Read(buffer, binary.BigEndian, &frame.maximumData)
-	return frame
+	var err error
+	if _, err = buffer.ReadByte(); err != nil { // Discard frame type
+		return nil, ErrTruncatedFrame
+	}
+	if frame.maximumData, err = ReadVarInt(buffer); err != nil {
+		return nil, ErrInvalidVarInt
+	}
+	return frame, nil
+}
+func (frame MaxDataFrame) String() string {
+	return fmt.Sprintf("%v(max=%d)", frame.FrameType(), frame.maximumData)
 }
 
 type MaxStreamDataFrame struct {
-	streamId uint64
+	streamId          uint64
 	maximumStreamData uint64
 }
+
 func (frame MaxStreamDataFrame) FrameType() FrameType { return MaxStreamDataType }
+func (frame MaxStreamDataFrame) Size() int {
+	return 1 + varIntSize(frame.streamId) + varIntSize(frame.maximumStreamData)
+}
 func (frame MaxStreamDataFrame) writeTo(buffer *bytes.Buffer) {
 	binary.Write(buffer, binary.BigEndian, frame.FrameType())
 	WriteVarInt(buffer, frame.streamId)
 	WriteVarInt(buffer, frame.maximumStreamData)
 }
-func NewMaxStreamDataFrame(buffer *bytes.Reader) *MaxStreamDataFrame {
+func NewMaxStreamDataFrame(buffer *bytes.Reader) (*MaxStreamDataFrame, error) {
 	frame := new(MaxStreamDataFrame)
-	buffer.ReadByte()  // Discard frame type
-	frame.streamId, _ = ReadVarInt(buffer)
-	frame.maximumStreamData, _ = ReadVarInt(buffer)
-	return frame
+	var err error
+	if _, err = buffer.ReadByte(); err != nil { // Discard frame type
+		return nil, ErrTruncatedFrame
+	}
+	if frame.streamId, err = ReadVarInt(buffer); err != nil {
+		return nil, ErrInvalidVarInt
+	}
+	if frame.maximumStreamData, err = ReadVarInt(buffer); err != nil {
+		return nil, ErrInvalidVarInt
+	}
+	return frame, nil
+}
+func (frame MaxStreamDataFrame) String() string {
+	return fmt.Sprintf("%v(stream=%d,max=%d)", frame.FrameType(), frame.streamId, frame.maximumStreamData)
 }
 
 type MaxStreamIdFrame struct {
 	maximumStreamId uint64
 }
+
 func (frame MaxStreamIdFrame) FrameType() FrameType { return MaxStreamIdType }
+func (frame MaxStreamIdFrame) Size() int { return 1 + varIntSize(frame.maximumStreamId) }
 func (frame MaxStreamIdFrame) writeTo(buffer *bytes.Buffer) {
 	binary.Write(buffer, binary.BigEndian, frame.FrameType())
 	WriteVarInt(buffer, frame.maximumStreamId)
 }
-func NewMaxStreamIdFrame(buffer *bytes.Reader) *MaxStreamIdFrame {
+func NewMaxStreamIdFrame(buffer *bytes.Reader) (*MaxStreamIdFrame, error) {
 	frame := new(MaxStreamIdFrame)
-	buffer.ReadByte()  // Discard frame type
-	frame.maximumStreamId, _ = ReadVarInt(buffer)
-	return frame
+	var err error
+	if _, err = buffer.ReadByte(); err != nil { // Discard frame type
+		return nil, ErrTruncatedFrame
+	}
+	if frame.maximumStreamId, err = ReadVarInt(buffer); err != nil {
+		return nil, ErrInvalidVarInt
+	}
+	return frame, nil
+}
+func (frame MaxStreamIdFrame) String() string {
+	return fmt.Sprintf("%v(max=%d)", frame.FrameType(), frame.maximumStreamId)
 }
-
 
 type PingFrame struct {
 	length uint8
-	data []byte
+	data   []byte
 }
+
 func (frame PingFrame) FrameType() FrameType { return PingType }
+func (frame PingFrame) Size() int { return 2 + int(frame.length) }
 func (frame PingFrame) writeTo(buffer *bytes.Buffer) {
 	binary.Write(buffer, binary.BigEndian, frame.FrameType())
 	buffer.WriteByte(frame.length)
@@ -225,63 +418,107 @@ func (frame PingFrame) writeTo(buffer *bytes.Buffer) {
 		buffer.Write(frame.data)
 	}
 }
-func NewPingFrame(buffer *bytes.Reader) *PingFrame {
+func NewPingFrame(buffer *bytes.Reader) (*PingFrame, error) {
 	frame := new(PingFrame)
-	buffer.ReadByte()  // Discard frame type
-	frame.length, _ = buffer.ReadByte()
+	var err error
+	if _, err = buffer.ReadByte(); err != nil { // Discard frame type
+		return nil, ErrTruncatedFrame
+	}
+	if frame.length, err = buffer.ReadByte(); err != nil {
+		return nil, ErrTruncatedFrame
+	}
 	if frame.length > 0 {
 		frame.data = make([]byte, frame.length, frame.length)
-		buffer.Read(frame.data)
+		if _, err = io.ReadFull(buffer, frame.data); err != nil {
+			return nil, ErrTruncatedFrame
+		}
 	}
-	return frame
+	return frame, nil
+}
+func (frame PingFrame) String() string {
+	return fmt.Sprintf("%v(len=%d)", frame.FrameType(), frame.length)
 }
 
 type BlockedFrame struct {
 	offset uint64
 }
+
 func (frame BlockedFrame) FrameType() FrameType { return BlockedType }
+func (frame BlockedFrame) Size() int { return 1 + varIntSize(frame.offset) }
 func (frame BlockedFrame) writeTo(buffer *bytes.Buffer) {
 	binary.Write(buffer, binary.BigEndian, frame.FrameType())
 	WriteVarInt(buffer, frame.offset)
 }
-func NewBlockedFrame(buffer *bytes.Reader) *BlockedFrame {
+func NewBlockedFrame(buffer *bytes.Reader) (*BlockedFrame, error) {
 	frame := new(BlockedFrame)
-	buffer.ReadByte()  // Discard frame type
-	frame.offset, _ = ReadVarInt(buffer)
-	return frame
+	var err error
+	if _, err = buffer.ReadByte(); err != nil { // Discard frame type
+		return nil, ErrTruncatedFrame
+	}
+	if frame.offset, err = ReadVarInt(buffer); err != nil {
+		return nil, ErrInvalidVarInt
+	}
+	return frame, nil
+}
+func (frame BlockedFrame) String() string {
+	return fmt.Sprintf("%v(offset=%d)", frame.FrameType(), frame.offset)
 }
 
 type StreamBlockedFrame struct {
 	streamId uint64
 	offset   uint64
 }
+
 func (frame StreamBlockedFrame) FrameType() FrameType { return StreamBlockedType }
+func (frame StreamBlockedFrame) Size() int {
+	return 1 + varIntSize(frame.streamId) + varIntSize(frame.offset)
+}
 func (frame StreamBlockedFrame) writeTo(buffer *bytes.Buffer) {
 	binary.Write(buffer, binary.BigEndian, frame.FrameType())
 	WriteVarInt(buffer, frame.streamId)
 	WriteVarInt(buffer, frame.offset)
 }
-func NewStreamBlockedFrame(buffer *bytes.Reader) *StreamBlockedFrame {
+func NewStreamBlockedFrame(buffer *bytes.Reader) (*StreamBlockedFrame, error) {
 	frame := new(StreamBlockedFrame)
-	buffer.ReadByte()  // Discard frame type
-	frame.streamId, _ = ReadVarInt(buffer)
-	frame.offset, _ = ReadVarInt(buffer)
-	return frame
+	var err error
+	if _, err = buffer.ReadByte(); err != nil { // Discard frame type
+		return nil, ErrTruncatedFrame
+	}
+	if frame.streamId, err = ReadVarInt(buffer); err != nil {
+		return nil, ErrInvalidVarInt
+	}
+	if frame.offset, err = ReadVarInt(buffer); err != nil {
+		return nil, ErrInvalidVarInt
+	}
+	return frame, nil
+}
+func (frame StreamBlockedFrame) String() string {
+	return fmt.Sprintf("%v(stream=%d,offset=%d)", frame.FrameType(), frame.streamId, frame.offset)
 }
 
 type StreamIdBlockedFrame struct {
 	streamId uint64
 }
+
 func (frame StreamIdBlockedFrame) FrameType() FrameType { return StreamIdBlockedType }
+func (frame StreamIdBlockedFrame) Size() int { return 1 + varIntSize(frame.streamId) }
 func (frame StreamIdBlockedFrame) writeTo(buffer *bytes.Buffer) {
 	binary.Write(buffer, binary.BigEndian, frame.FrameType())
 	WriteVarInt(buffer, frame.streamId)
 }
-func NewStreamIdNeededFrame(buffer *bytes.Reader) *StreamIdBlockedFrame {
+func NewStreamIdNeededFrame(buffer *bytes.Reader) (*StreamIdBlockedFrame, error) {
 	frame := new(StreamIdBlockedFrame)
-	buffer.ReadByte()  // Discard frame type
-	frame.streamId, _ = ReadVarInt(buffer)
-	return frame
+	var err error
+	if _, err = buffer.ReadByte(); err != nil { // Discard frame type
+		return nil, ErrTruncatedFrame
+	}
+	if frame.streamId, err = ReadVarInt(buffer); err != nil {
+		return nil, ErrInvalidVarInt
+	}
+	return frame, nil
+}
+func (frame StreamIdBlockedFrame) String() string {
+	return fmt.Sprintf("%v(stream=%d)", frame.FrameType(), frame.streamId)
 }
 
 type NewConnectionIdFrame struct {
@@ -289,38 +526,66 @@ type NewConnectionIdFrame struct {
 	connectionId        uint64
 	statelessResetToken [16]byte
 }
+
 func (frame NewConnectionIdFrame) FrameType() FrameType { return NewConnectionIdType }
+func (frame NewConnectionIdFrame) Size() int {
+	return 1 + varIntSize(frame.sequence) + varIntSize(frame.connectionId) + len(frame.statelessResetToken)
+}
 func (frame NewConnectionIdFrame) writeTo(buffer *bytes.Buffer) {
 	binary.Write(buffer, binary.BigEndian, frame.FrameType())
 	WriteVarInt(buffer, frame.sequence)
 	WriteVarInt(buffer, frame.connectionId)
 	binary.Write(buffer, binary.BigEndian, frame.statelessResetToken)
 }
-func NewNewConnectionIdFrame(buffer *bytes.Reader) *NewConnectionIdFrame {
+func NewNewConnectionIdFrame(buffer *bytes.Reader) (*NewConnectionIdFrame, error) {
 	frame := new(NewConnectionIdFrame)
-	buffer.ReadByte()  // Discard frame type
-	frame.sequence, _ = ReadVarInt(buffer)
-	frame.connectionId, _ = ReadVarInt(buffer)
-	binary.Read(buffer, binary.BigEndian, &frame.statelessResetToken)
-	return frame
+	var err error
+	if _, err = buffer.ReadByte(); err != nil { // Discard frame type
+		return nil, ErrTruncatedFrame
+	}
+	if frame.sequence, err = ReadVarInt(buffer); err != nil {
+		return nil, ErrInvalidVarInt
+	}
+	if frame.connectionId, err = ReadVarInt(buffer); err != nil {
+		return nil, ErrInvalidVarInt
+	}
+	if err = binary.Read(buffer, binary.BigEndian, &frame.statelessResetToken); err != nil {
+		return nil, ErrTruncatedFrame
+	}
+	return frame, nil
+}
+func (frame NewConnectionIdFrame) String() string {
+	return fmt.Sprintf("%v(seq=%d,cid=%#x)", frame.FrameType(), frame.sequence, frame.connectionId)
 }
 
 type StopSendingFrame struct {
 	streamId  uint64
 	errorCode uint16
 }
+
 func (frame StopSendingFrame) FrameType() FrameType { return StopSendingType }
+func (frame StopSendingFrame) Size() int { return 1 + varIntSize(frame.streamId) + 2 }
 func (frame StopSendingFrame) writeTo(buffer *bytes.Buffer) {
 	binary.Write(buffer, binary.BigEndian, frame.FrameType())
 	WriteVarInt(buffer, frame.streamId)
 	binary.Write(buffer, binary.BigEndian, frame.errorCode)
 }
-func NewStopSendingFrame(buffer *bytes.Reader) *StopSendingFrame {
+func NewStopSendingFrame(buffer *bytes.Reader) (*StopSendingFrame, error) {
 	frame := new(StopSendingFrame)
-	buffer.ReadByte()  // Discard frame type
-	frame.streamId, _ = ReadVarInt(buffer)
-	binary.Read(buffer, binary.BigEndian, &frame.errorCode)
-	return frame
+	var err error
+	if _, err = buffer.ReadByte(); err != nil { // Discard frame type
+		return nil, ErrTruncatedFrame
+	}
+	if frame.streamId, err = ReadVarInt(buffer); err != nil {
+		return nil, ErrInvalidVarInt
+	}
+	if err = binary.Read(buffer, binary.BigEndian, &frame.errorCode); err != nil {
+		return nil, ErrTruncatedFrame
+	}
+	return frame, nil
+}
+func (frame StopSendingFrame) String() string {
+	return fmt.Sprintf("%v(stream=%d,err=%#x)", frame.FrameType(), frame.streamId, frame.errorCode)
 }
 
 type PongFrame struct {
@@ -328,67 +593,184 @@ type PongFrame struct {
 }
 
 func (frame PongFrame) FrameType() FrameType { return PongType }
+func (frame PongFrame) Size() int            { return 2 + int(frame.length) }
 
-func NewPongFrame(buffer *bytes.Reader) *PongFrame {
+func NewPongFrame(buffer *bytes.Reader) (*PongFrame, error) {
 	frame := new(PongFrame)
-	buffer.ReadByte()  // Discard frame type
-	frame.length, _ = buffer.ReadByte()
+	var err error
+	if _, err = buffer.ReadByte(); err != nil { // Discard frame type
+		return nil, ErrTruncatedFrame
+	}
+	if frame.length, err = buffer.ReadByte(); err != nil {
+		return nil, ErrTruncatedFrame
+	}
 	if frame.length > 0 {
 		frame.data = make([]byte, frame.length, frame.length)
-		buffer.Read(frame.data)
+		if _, err = io.ReadFull(buffer, frame.data); err != nil {
+			return nil, ErrTruncatedFrame
+		}
 	}
-	return frame
+	return frame, nil
+}
+func (frame PongFrame) String() string {
+	return fmt.Sprintf("%v(len=%d)", frame.FrameType(), frame.length)
 }
 
-type AckFrame struct {
-	largestAcknowledged       uint64
-	ackDelay                  uint64
-	ackBlockCount              uint64
-	ackBlocks                 []AckBlock
+// AckRange is a contiguous, inclusive range of acknowledged packet numbers,
+// i.e. [start, end]. AckFrame keeps its ranges ordered from the most recent
+// (largest end) to the oldest, matching decoding order.
+type AckRange struct {
+	start uint64
+	end   uint64
 }
-type AckBlock struct {
-	gap uint64
-	block uint64
+
+// AckFrame always carries at least one AckRange on the wire (the mandatory
+// largestAcknowledged/firstACKRange pair) — ReadAckFrame, NewAckFrame and
+// appendRange all guarantee that invariant. Size and writeTo both assume it
+// and are only meaningful once it holds.
+type AckFrame struct {
+	largestAcknowledged uint64
+	ackDelay            uint64
+	ackRanges           []AckRange
 }
+
 func (frame AckFrame) FrameType() FrameType { return AckType }
+func (frame AckFrame) Size() int {
+	size := 1 + varIntSize(frame.largestAcknowledged) + varIntSize(frame.ackDelay)
+	size += varIntSize(uint64(len(frame.ackRanges) - 1))
+	first := frame.ackRanges[0]
+	size += varIntSize(first.end - first.start)
+	smallest := first.start
+	for _, r := range frame.ackRanges[1:] {
+		size += varIntSize(smallest-r.end-2) + varIntSize(r.end-r.start)
+		smallest = r.start
+	}
+	return size
+}
 func (frame AckFrame) writeTo(buffer *bytes.Buffer) {
 	binary.Write(buffer, binary.BigEndian, frame.FrameType())
 	WriteVarInt(buffer, frame.largestAcknowledged)
 	WriteVarInt(buffer, frame.ackDelay)
-	WriteVarInt(buffer, frame.ackBlockCount)
-	for i, ack := range frame.ackBlocks {
-		if i > 0 {
-			WriteVarInt(buffer, ack.gap)
-		}
-		WriteVarInt(buffer, ack.block)
+	WriteVarInt(buffer, uint64(len(frame.ackRanges)-1))
+
+	first := frame.ackRanges[0]
+	WriteVarInt(buffer, first.end-first.start)
+	smallest := first.start
+	for _, r := range frame.ackRanges[1:] {
+		WriteVarInt(buffer, smallest-r.end-2) // gap: no gap precedes the first range
+		WriteVarInt(buffer, r.end-r.start)
+		smallest = r.start
+	}
+}
+
+// appendRange appends [start, end] to frame.ackRanges, rejecting ranges
+// that arrive out of order, overlapping, or adjacent to a previously
+// appended one. Per RFC 9000 §19.3.1 consecutive ranges must be separated
+// by at least one unacknowledged packet, or the gap preceding them can't
+// be encoded (writeTo computes it as smallest-r.end-2, which underflows
+// when the ranges are contiguous).
+func (frame *AckFrame) appendRange(start, end uint64) error {
+	if start > end {
+		return ErrInvalidAckRange
 	}
+	if len(frame.ackRanges) > 0 && end+1 >= frame.ackRanges[len(frame.ackRanges)-1].start {
+		return ErrInvalidAckRange
+	}
+	frame.ackRanges = append(frame.ackRanges, AckRange{start: start, end: end})
+	return nil
 }
-func ReadAckFrame(buffer *bytes.Reader) *AckFrame {
+
+// ReadAckFrame decodes an ACK frame from buffer. It reconstructs the
+// contiguous acknowledged ranges from largestAcknowledged, the first ACK
+// range length, and each (gap, range length) pair per RFC 9000 §19.3.1,
+// invoking onRange once per range in descending packet number order.
+// onRange may be nil. The decoded ranges are also kept in the returned
+// frame so Contains can be queried afterwards.
+func ReadAckFrame(buffer *bytes.Reader, onRange func(start, end uint64)) (*AckFrame, error) {
 	frame := new(AckFrame)
-	buffer.ReadByte()  // Discard frame byte
+	var err error
+	if _, err = buffer.ReadByte(); err != nil { // Discard frame byte
+		return nil, ErrTruncatedFrame
+	}
 
-	frame.largestAcknowledged, _ = ReadVarInt(buffer)
-	frame.ackDelay, _ = ReadVarInt(buffer)
-	frame.ackBlockCount, _ = ReadVarInt(buffer)
+	if frame.largestAcknowledged, err = ReadVarInt(buffer); err != nil {
+		return nil, ErrInvalidVarInt
+	}
+	if frame.ackDelay, err = ReadVarInt(buffer); err != nil {
+		return nil, ErrInvalidVarInt
+	}
+	var ackRangeCount uint64
+	if ackRangeCount, err = ReadVarInt(buffer); err != nil {
+		return nil, ErrInvalidVarInt
+	}
+	var firstRangeLength uint64
+	if firstRangeLength, err = ReadVarInt(buffer); err != nil {
+		return nil, ErrInvalidVarInt
+	}
+	if firstRangeLength > frame.largestAcknowledged {
+		return nil, ErrInvalidAckRange
+	}
 
-	firstBlock := AckBlock{}
-	firstBlock.block, _ = ReadVarInt(buffer)
+	largest := frame.largestAcknowledged
+	smallest := largest - firstRangeLength
+	if err = frame.appendRange(smallest, largest); err != nil {
+		return nil, err
+	}
+	if onRange != nil {
+		onRange(smallest, largest)
+	}
 
 	var i uint64
-	for i = 0; i < frame.ackBlockCount; i++ {
-		ack := AckBlock{}
-		ack.gap, _ = ReadVarInt(buffer)
-		ack.block, _ = ReadVarInt(buffer)
-		frame.ackBlocks = append(frame.ackBlocks, ack)
+	for i = 0; i < ackRangeCount; i++ {
+		var gap, rangeLength uint64
+		if gap, err = ReadVarInt(buffer); err != nil {
+			return nil, ErrInvalidVarInt
+		}
+		if rangeLength, err = ReadVarInt(buffer); err != nil {
+			return nil, ErrInvalidVarInt
+		}
+		if smallest < gap+2 {
+			return nil, ErrInvalidAckRange
+		}
+		largest = smallest - gap - 2
+		if rangeLength > largest {
+			return nil, ErrInvalidAckRange
+		}
+		smallest = largest - rangeLength
+		if err = frame.appendRange(smallest, largest); err != nil {
+			return nil, err
+		}
+		if onRange != nil {
+			onRange(smallest, largest)
+		}
 	}
-	return frame
+	return frame, nil
+}
+
+// Contains reports whether pn was acknowledged by this frame.
+func (frame AckFrame) Contains(pn uint64) bool {
+	for _, r := range frame.ackRanges {
+		if pn > r.end {
+			return false // ranges are sorted descending, nothing further can match
+		}
+		if pn >= r.start {
+			return true
+		}
+	}
+	return false
 }
-func NewAckFrame(largestAcknowledged uint64, ackBlockCount uint64) *AckFrame {
+
+func (frame AckFrame) String() string {
+	return fmt.Sprintf("%v(largest=%d,delay=%d,ranges=%v)", frame.FrameType(), frame.largestAcknowledged, frame.ackDelay, frame.ackRanges)
+}
+
+// NewAckFrame builds an AckFrame acknowledging the single contiguous range
+// [largestAcknowledged-rangeLength, largestAcknowledged].
+func NewAckFrame(largestAcknowledged uint64, rangeLength uint64) *AckFrame {
 	frame := new(AckFrame)
 	frame.largestAcknowledged = largestAcknowledged
-	frame.ackBlockCount = 0
 	frame.ackDelay = 0
-	frame.ackBlocks = append(frame.ackBlocks, AckBlock{0, ackBlockCount})
+	frame.ackRanges = []AckRange{{start: largestAcknowledged - rangeLength, end: largestAcknowledged}}
 	return frame
 }
 
@@ -397,12 +779,23 @@ type StreamFrame struct {
 	lenBit bool
 	offBit bool
 
-	streamId uint64
-	offset   uint64
-	length   uint64
+	streamId   uint64
+	offset     uint64
+	length     uint64
 	streamData []byte
 }
+
 func (frame StreamFrame) FrameType() FrameType { return StreamType }
+func (frame StreamFrame) Size() int {
+	size := 1 + varIntSize(frame.streamId)
+	if frame.offBit {
+		size += varIntSize(frame.offset)
+	}
+	if frame.lenBit {
+		size += varIntSize(frame.length)
+	}
+	return size + len(frame.streamData)
+}
 func (frame StreamFrame) writeTo(buffer *bytes.Buffer) {
 	typeByte := byte(frame.FrameType())
 	if frame.finBit {
@@ -424,21 +817,47 @@ func (frame StreamFrame) writeTo(buffer *bytes.Buffer) {
 	}
 	buffer.Write(frame.streamData)
 }
-func ReadStreamFrame(buffer *bytes.Reader, conn *Connection) *StreamFrame {
+func ReadStreamFrame(buffer *bytes.Reader, conn *Connection) (*StreamFrame, error) {
 	frame := new(StreamFrame)
-	typeByte, _ := buffer.ReadByte()
+	typeByte, err := buffer.ReadByte()
+	if err != nil {
+		return nil, ErrTruncatedFrame
+	}
 	frame.finBit = (typeByte & 0x01) == 0x01
 	frame.lenBit = (typeByte & 0x02) == 0x02
 	frame.offBit = (typeByte & 0x04) == 0x04
 
-	frame.streamId, _ = ReadVarInt(buffer)
+	if frame.streamId, err = ReadVarInt(buffer); err != nil {
+		return nil, ErrInvalidVarInt
+	}
 	if frame.offBit {
-		frame.offset, _ = ReadVarInt(buffer)
+		if frame.offset, err = ReadVarInt(buffer); err != nil {
+			return nil, ErrInvalidVarInt
+		}
 	}
 	if frame.lenBit {
-		frame.length, _ = ReadVarInt(buffer)
+		if frame.length, err = ReadVarInt(buffer); err != nil {
+			return nil, ErrInvalidVarInt
+		}
+		if frame.length > uint64(buffer.Len()) {
+			return nil, ErrTruncatedFrame
+		}
+		frame.streamData = make([]byte, frame.length, frame.length)
+		if _, err = io.ReadFull(buffer, frame.streamData); err != nil {
+			return nil, ErrTruncatedFrame
+		}
+	} else {
+		// No length field means the stream data runs to the end of the packet.
+		frame.length = uint64(buffer.Len())
+		frame.streamData = make([]byte, frame.length, frame.length)
+		if _, err = io.ReadFull(buffer, frame.streamData); err != nil {
+			return nil, ErrTruncatedFrame
+		}
 	}
-	return frame
+	return frame, nil
+}
+func (frame StreamFrame) String() string {
+	return fmt.Sprintf("%v(stream=%d,offset=%d,length=%d,fin=%v)", frame.FrameType(), frame.streamId, frame.offset, frame.length, frame.finBit)
 }
 func NewStreamFrame(streamId uint32, stream *Stream, data []byte, finBit bool) *StreamFrame {
 	frame := new(StreamFrame)